@@ -0,0 +1,76 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parser
+
+import (
+	"strings"
+
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// ScanComments scans source for "//" line comments and builds a CommentMap that attaches each
+// comment to the expression whose recorded position, per info.GetPositions, is the closest one
+// following the comment, mirroring the way go/ast attaches a comment to the node it precedes.
+//
+// This is a plain text scan, not a lexer: it has no notion of string or bytes literals, so a "//"
+// occurring inside a quoted literal is misidentified as a comment start. That is an acceptable
+// limitation for the "just // for now" scope of this feature; a lexer-aware scan can replace this
+// implementation later without changing the CommentMap API or WithCommentMap's contract.
+func ScanComments(source string, info *exprpb.SourceInfo) *CommentMap {
+	comments := NewCommentMap()
+	positions := info.GetPositions()
+	if len(positions) == 0 {
+		return comments
+	}
+	line := 1
+	for i := 0; i < len(source); i++ {
+		switch {
+		case source[i] == '\n':
+			line++
+		case source[i] == '/' && i+1 < len(source) && source[i+1] == '/':
+			end := strings.IndexByte(source[i:], '\n')
+			var text string
+			if end < 0 {
+				text = source[i+2:]
+			} else {
+				text = source[i+2 : i+end]
+			}
+			text = strings.TrimPrefix(text, " ")
+			if id, ok := nearestExprAfter(positions, int32(i)); ok {
+				comments.Add(id, Comment{Text: text, Line: line})
+			}
+			if end < 0 {
+				return comments
+			}
+			i += end
+			line++
+		}
+	}
+	return comments
+}
+
+// nearestExprAfter returns the id whose position is the smallest one at or after offset, i.e. the
+// expression a comment starting at offset most immediately precedes.
+func nearestExprAfter(positions map[int64]int32, offset int32) (int64, bool) {
+	var nearest int64
+	var nearestPos int32
+	found := false
+	for id, pos := range positions {
+		if pos >= offset && (!found || pos < nearestPos) {
+			nearest, nearestPos, found = id, pos, true
+		}
+	}
+	return nearest, found
+}
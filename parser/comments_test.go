@@ -0,0 +1,78 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+func TestScanCommentsAttachesToNearestExpr(t *testing.T) {
+	source := "// leading comment\na && b"
+	info := &exprpb.SourceInfo{
+		Positions: map[int64]int32{
+			1: int32(strings.Index(source, "a")),
+			2: int32(strings.Index(source, "b")),
+		},
+	}
+	comments := ScanComments(source, info)
+	got := comments.CommentsFor(1)
+	if len(got) != 1 || got[0].Text != "leading comment" || got[0].Line != 1 {
+		t.Fatalf("got %+v, wanted one comment {Text: \"leading comment\", Line: 1} attached to id 1", got)
+	}
+	if len(comments.CommentsFor(2)) != 0 {
+		t.Errorf("id 2 should have no comments, got %+v", comments.CommentsFor(2))
+	}
+}
+
+func TestScanCommentsIgnoresSourceWithNoPositions(t *testing.T) {
+	comments := ScanComments("// orphaned comment", nil)
+	if len(comments.CommentsFor(1)) != 0 {
+		t.Errorf("expected no comments without SourceInfo positions, got %+v", comments.CommentsFor(1))
+	}
+}
+
+func TestWithCommentMapRoundTrip(t *testing.T) {
+	source := "// leading comment\na && b"
+	info := &exprpb.SourceInfo{
+		Positions: map[int64]int32{
+			1: int32(strings.Index(source, "a")),
+			2: int32(strings.Index(source, "b")),
+		},
+	}
+	comments := ScanComments(source, info)
+	expr := callExpr(operatorAnd, withID(1, identExpr("a")), withID(2, identExpr("b")))
+	out, err := Unparse(expr, nil, WithCommentMap(comments))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "// leading comment\na && b"
+	if out != want {
+		t.Errorf("got %q, wanted %q", out, want)
+	}
+}
+
+func TestWithCommentMapNoopWithoutComments(t *testing.T) {
+	expr := callExpr(operatorAnd, identExpr("a"), identExpr("b"))
+	out, err := Unparse(expr, nil, WithCommentMap(NewCommentMap()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "a && b" {
+		t.Errorf("got %q, wanted %q", out, "a && b")
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// Dump renders expr as a compact, canonical, parenthesized S-expression-like form of the AST,
+// e.g. `call{_+_ ident{x} int{3}}`, `sel{ident{a} b}`, `has{sel{ident{a} b}}`,
+// `list{int{1} int{2}}`, `map{str{"k"}:int{1}}`.
+//
+// Unlike Unparse, Dump is total, deterministic, and independent of SourceInfo: it never prints
+// expr ids or source positions, so the same tree always produces the same string regardless of
+// where it came from. This makes it a diff-friendly golden format for parser and unparser
+// regression tests. Entries within a call, list, map, or struct are printed in their existing
+// slice order, which is stable across proto field additions since new fields simply don't appear.
+func Dump(expr *exprpb.Expr) string {
+	var sb strings.Builder
+	dumpExpr(&sb, expr)
+	return sb.String()
+}
+
+// DumpSourceInfo renders info as a compact, canonical, deterministic string, for use alongside
+// Dump when a golden test needs to assert on source positions or macro call metadata. Entries
+// keyed by expression id are sorted numerically so the output does not depend on Go's unordered
+// map iteration.
+func DumpSourceInfo(info *exprpb.SourceInfo) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "source{location=%s positions={", strconv.Quote(info.GetLocation()))
+	positions := info.GetPositions()
+	for i, id := range positionKeys(positions) {
+		if i > 0 {
+			sb.WriteString(" ")
+		}
+		fmt.Fprintf(&sb, "%d:%d", id, positions[id])
+	}
+	sb.WriteString("} macros={")
+	macroCalls := info.GetMacroCalls()
+	for i, id := range macroCallKeys(macroCalls) {
+		if i > 0 {
+			sb.WriteString(" ")
+		}
+		fmt.Fprintf(&sb, "%d:", id)
+		dumpExpr(&sb, macroCalls[id])
+	}
+	sb.WriteString("}}")
+	return sb.String()
+}
+
+// positionKeys returns the expr ids of m in ascending order.
+func positionKeys(m map[int64]int32) []int64 {
+	keys := make([]int64, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+// macroCallKeys returns the expr ids of m in ascending order.
+func macroCallKeys(m map[int64]*exprpb.Expr) []int64 {
+	keys := make([]int64, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+func dumpExpr(sb *strings.Builder, expr *exprpb.Expr) {
+	switch expr.GetExprKind().(type) {
+	case *exprpb.Expr_ConstExpr:
+		dumpConst(sb, expr.GetConstExpr())
+	case *exprpb.Expr_IdentExpr:
+		fmt.Fprintf(sb, "ident{%s}", expr.GetIdentExpr().GetName())
+	case *exprpb.Expr_SelectExpr:
+		dumpSelect(sb, expr.GetSelectExpr())
+	case *exprpb.Expr_CallExpr:
+		dumpCall(sb, expr.GetCallExpr())
+	case *exprpb.Expr_ListExpr:
+		dumpList(sb, expr.GetListExpr())
+	case *exprpb.Expr_StructExpr:
+		dumpStruct(sb, expr.GetStructExpr())
+	case *exprpb.Expr_ComprehensionExpr:
+		dumpComprehension(sb, expr.GetComprehensionExpr())
+	default:
+		sb.WriteString("nil{}")
+	}
+}
+
+func dumpConst(sb *strings.Builder, c *exprpb.Constant) {
+	switch v := c.GetConstantKind().(type) {
+	case *exprpb.Constant_BoolValue:
+		fmt.Fprintf(sb, "bool{%t}", v.BoolValue)
+	case *exprpb.Constant_BytesValue:
+		fmt.Fprintf(sb, "bytes{%s}", strconv.Quote(string(v.BytesValue)))
+	case *exprpb.Constant_DoubleValue:
+		fmt.Fprintf(sb, "double{%s}", strconv.FormatFloat(v.DoubleValue, 'g', -1, 64))
+	case *exprpb.Constant_Int64Value:
+		fmt.Fprintf(sb, "int{%d}", v.Int64Value)
+	case *exprpb.Constant_NullValue:
+		sb.WriteString("null{}")
+	case *exprpb.Constant_StringValue:
+		fmt.Fprintf(sb, "str{%s}", strconv.Quote(v.StringValue))
+	case *exprpb.Constant_Uint64Value:
+		fmt.Fprintf(sb, "uint{%d}", v.Uint64Value)
+	default:
+		sb.WriteString("const{}")
+	}
+}
+
+func dumpSelect(sb *strings.Builder, sel *exprpb.Expr_Select) {
+	if sel.GetTestOnly() {
+		sb.WriteString("has{")
+		dumpSelectFields(sb, sel)
+		sb.WriteString("}")
+		return
+	}
+	dumpSelectFields(sb, sel)
+}
+
+func dumpSelectFields(sb *strings.Builder, sel *exprpb.Expr_Select) {
+	sb.WriteString("sel{")
+	dumpExpr(sb, sel.GetOperand())
+	sb.WriteString(" ")
+	sb.WriteString(sel.GetField())
+	sb.WriteString("}")
+}
+
+func dumpCall(sb *strings.Builder, call *exprpb.Expr_Call) {
+	sb.WriteString("call{")
+	sb.WriteString(call.GetFunction())
+	if call.GetTarget() != nil {
+		sb.WriteString(" target=")
+		dumpExpr(sb, call.GetTarget())
+	}
+	for _, arg := range call.GetArgs() {
+		sb.WriteString(" ")
+		dumpExpr(sb, arg)
+	}
+	sb.WriteString("}")
+}
+
+func dumpList(sb *strings.Builder, l *exprpb.Expr_CreateList) {
+	sb.WriteString("list{")
+	for i, elem := range l.GetElements() {
+		if i > 0 {
+			sb.WriteString(" ")
+		}
+		dumpExpr(sb, elem)
+	}
+	sb.WriteString("}")
+}
+
+func dumpStruct(sb *strings.Builder, s *exprpb.Expr_CreateStruct) {
+	if s.GetMessageName() != "" {
+		sb.WriteString("obj{")
+		sb.WriteString(s.GetMessageName())
+		for _, entry := range s.GetEntries() {
+			sb.WriteString(" ")
+			sb.WriteString(entry.GetFieldKey())
+			sb.WriteString(":")
+			dumpExpr(sb, entry.GetValue())
+		}
+		sb.WriteString("}")
+		return
+	}
+	sb.WriteString("map{")
+	for i, entry := range s.GetEntries() {
+		if i > 0 {
+			sb.WriteString(" ")
+		}
+		dumpExpr(sb, entry.GetMapKey())
+		sb.WriteString(":")
+		dumpExpr(sb, entry.GetValue())
+	}
+	sb.WriteString("}")
+}
+
+func dumpComprehension(sb *strings.Builder, c *exprpb.Expr_Comprehension) {
+	sb.WriteString("compre{iter=")
+	sb.WriteString(c.GetIterVar())
+	sb.WriteString(" range=")
+	dumpExpr(sb, c.GetIterRange())
+	sb.WriteString(" accu=")
+	sb.WriteString(c.GetAccuVar())
+	sb.WriteString(" init=")
+	dumpExpr(sb, c.GetAccuInit())
+	sb.WriteString(" cond=")
+	dumpExpr(sb, c.GetLoopCondition())
+	sb.WriteString(" step=")
+	dumpExpr(sb, c.GetLoopStep())
+	sb.WriteString(" result=")
+	dumpExpr(sb, c.GetResult())
+	sb.WriteString("}")
+}
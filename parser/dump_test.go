@@ -0,0 +1,110 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parser
+
+import (
+	"testing"
+
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+func TestDumpCall(t *testing.T) {
+	assertDump(t, callExpr("_+_", identExpr("x"), intExpr(3)), "call{_+_ ident{x} int{3}}")
+}
+
+func TestDumpSelect(t *testing.T) {
+	sel := &exprpb.Expr{ExprKind: &exprpb.Expr_SelectExpr{
+		SelectExpr: &exprpb.Expr_Select{Operand: identExpr("a"), Field: "b"},
+	}}
+	assertDump(t, sel, "sel{ident{a} b}")
+}
+
+func TestDumpHas(t *testing.T) {
+	sel := &exprpb.Expr{ExprKind: &exprpb.Expr_SelectExpr{
+		SelectExpr: &exprpb.Expr_Select{Operand: identExpr("a"), Field: "b", TestOnly: true},
+	}}
+	assertDump(t, sel, "has{sel{ident{a} b}}")
+}
+
+func TestDumpList(t *testing.T) {
+	assertDump(t, listExpr(intExpr(1), intExpr(2)), "list{int{1} int{2}}")
+}
+
+func TestDumpMap(t *testing.T) {
+	m := &exprpb.Expr{ExprKind: &exprpb.Expr_StructExpr{
+		StructExpr: &exprpb.Expr_CreateStruct{
+			Entries: []*exprpb.Expr_CreateStruct_Entry{
+				{
+					KeyKind: &exprpb.Expr_CreateStruct_Entry_MapKey{MapKey: strExpr("k")},
+					Value:   intExpr(1),
+				},
+			},
+		},
+	}}
+	assertDump(t, m, `map{str{"k"}:int{1}}`)
+}
+
+func TestDumpComprehension(t *testing.T) {
+	expr := macroExpr("__result__", boolExpr(false), identExpr("__result__"), identExpr("__result__"), identExpr("__result__"))
+	want := "compre{iter=v range=ident{x} accu=__result__ init=bool{false} cond=ident{__result__} step=ident{__result__} result=ident{__result__}}"
+	assertDump(t, expr, want)
+}
+
+func TestDumpIsTotalAndDeterministic(t *testing.T) {
+	expr := macroExpr("__result__", boolExpr(true), notStrictlyFalse(identExpr("__result__")),
+		callExpr(operatorAnd, identExpr("__result__"), greaterZero()), identExpr("__result__"))
+	first := Dump(expr)
+	second := Dump(expr)
+	if first != second {
+		t.Errorf("Dump is not deterministic: got '%v' then '%v'", first, second)
+	}
+}
+
+func TestDumpSourceInfo(t *testing.T) {
+	info := &exprpb.SourceInfo{
+		Location: "my-file.cel",
+		Positions: map[int64]int32{
+			2: 4,
+			1: 0,
+		},
+		MacroCalls: map[int64]*exprpb.Expr{
+			3: callExpr("has", identExpr("a")),
+		},
+	}
+	got := DumpSourceInfo(info)
+	want := `source{location="my-file.cel" positions={1:0 2:4} macros={3:call{has ident{a}}}}`
+	if got != want {
+		t.Errorf("DumpSourceInfo() got '%v', wanted '%v'", got, want)
+	}
+}
+
+func TestDumpSourceInfoEmpty(t *testing.T) {
+	got := DumpSourceInfo(&exprpb.SourceInfo{})
+	want := `source{location="" positions={} macros={}}`
+	if got != want {
+		t.Errorf("DumpSourceInfo() got '%v', wanted '%v'", got, want)
+	}
+}
+
+func assertDump(t *testing.T, expr *exprpb.Expr, want string) {
+	t.Helper()
+	if got := Dump(expr); got != want {
+		t.Errorf("Dump() got '%v', wanted '%v'", got, want)
+	}
+}
+
+func strExpr(s string) *exprpb.Expr {
+	return &exprpb.Expr{ExprKind: &exprpb.Expr_ConstExpr{ConstExpr: &exprpb.Constant{ConstantKind: &exprpb.Constant_StringValue{StringValue: s}}}}
+}
@@ -35,12 +35,30 @@ import (
 // - Floating point values are converted to the small number of digits needed to represent the value.
 // - Spacing around punctuation marks may be lost.
 // - Parentheses will only be applied when they affect operator precedence.
-func Unparse(expr *exprpb.Expr, info *exprpb.SourceInfo) (string, error) {
-	un := &unparser{info: info}
+//
+// info may be nil, in which case every node is rendered with canonical, id-independent spacing;
+// see UnparseExpr for the dedicated entry point for this case.
+//
+// With no opts the notes above hold, except for byte literals: printable ASCII bytes are copied
+// through and every other byte is written as a \xNN escape, so the result re-parses to the same
+// bytes. Passing WithMaxLineLength
+// switches Unparse into a gofmt-style pretty-printer that wraps long subtrees across multiple,
+// consistently indented lines; see UnparseOption for the full set of knobs.
+func Unparse(expr *exprpb.Expr, info *exprpb.SourceInfo, opts ...UnparseOption) (string, error) {
+	o := defaultUnparserOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+	un := &unparser{info: info, options: o}
 	err := un.visit(expr)
 	if err != nil {
 		return "", err
 	}
+	// Pretty-printed output carries its own newlines; the source line-offset reconstruction
+	// below only makes sense for the legacy single-line rendering.
+	if o.maxLineLength > 0 {
+		return un.str.String(), nil
+	}
 	// Test whether newlines need to be applied.
 	breaks := info.GetLineOffsets()
 	if len(breaks) <= 1 {
@@ -60,18 +78,29 @@ func Unparse(expr *exprpb.Expr, info *exprpb.SourceInfo) (string, error) {
 	return string(txt), nil
 }
 
+// UnparseExpr renders expr as canonical CEL source with no dependency on SourceInfo: a single
+// space around every binary operator and no extraneous padding, with output that depends only on
+// the shape of expr and never on its ids. This is the supported entry point for rendering an Expr
+// tree that a caller built programmatically in Go rather than one that came from Parse, e.g. to
+// turn a rule constructed in Go back into human-readable CEL source.
+func UnparseExpr(expr *exprpb.Expr) (string, error) {
+	return Unparse(expr, nil)
+}
+
 // unparser visits an expression to reconstruct a human-readable string from an AST.
 type unparser struct {
-	info   *exprpb.SourceInfo
-	str    strings.Builder
-	offset int32
+	info        *exprpb.SourceInfo
+	str         strings.Builder
+	offset      int32
+	options     *unparserOptions
+	indentLevel int
 }
 
 func (un *unparser) visit(expr *exprpb.Expr) error {
+	un.writeLeadingComments(expr.GetId())
 	switch expr.ExprKind.(type) {
 	case *exprpb.Expr_CallExpr:
 		return un.visitCall(expr)
-	// TODO: Comprehensions are currently not supported.
 	case *exprpb.Expr_ComprehensionExpr:
 		return un.visitComprehension(expr)
 	case *exprpb.Expr_ConstExpr:
@@ -127,6 +156,9 @@ func (un *unparser) visitCall(expr *exprpb.Expr) error {
 func (un *unparser) visitCallBinary(expr *exprpb.Expr) error {
 	c := expr.GetCallExpr()
 	fun := c.GetFunction()
+	if un.options.maxLineLength > 0 && (fun == operators.LogicalAnd || fun == operators.LogicalOr) {
+		return un.visitLogicalChain(expr)
+	}
 	args := c.GetArgs()
 	lhs := args[0]
 	// add parens if the current operator is lower precedence than the lhs expr operator.
@@ -154,6 +186,9 @@ func (un *unparser) visitCallBinary(expr *exprpb.Expr) error {
 }
 
 func (un *unparser) visitCallConditional(expr *exprpb.Expr) error {
+	if un.options.maxLineLength > 0 {
+		return un.visitCallConditionalWrapped(expr)
+	}
 	c := expr.GetCallExpr()
 	args := c.GetArgs()
 	// add parens if operand is a conditional itself.
@@ -190,14 +225,8 @@ func (un *unparser) visitCallFunc(expr *exprpb.Expr) error {
 	un.str.WriteString(fun)
 	un.pad(expr.GetId())
 	un.str.WriteString("(")
-	for i, arg := range args {
-		err := un.visit(arg)
-		if err != nil {
-			return err
-		}
-		if i < len(args)-1 {
-			un.str.WriteString(",")
-		}
+	if err := un.writeItems(args, ","); err != nil {
+		return err
 	}
 	un.str.WriteString(")")
 	return nil
@@ -230,27 +259,261 @@ func (un *unparser) visitCallUnary(expr *exprpb.Expr) error {
 		return fmt.Errorf("cannot unmangle operator: %s", fun)
 	}
 	un.str.WriteString(unmangled)
-	return un.visit(args[0])
+	// add parens if the operand is itself a binary or ternary operator, e.g. -(a + b).
+	nested := isComplexOperator(args[0])
+	return un.visitMaybeNested(args[0], nested)
+}
+
+// isComplexOperator returns true if the expression is a call expression with more than one
+// argument, i.e. a binary or ternary operator rather than a unary one. Unary operators are
+// excluded so that e.g. --x is not parenthesized by default; WithAlwaysParenthesize still forces
+// parens there via visitMaybeNested.
+func isComplexOperator(expr *exprpb.Expr) bool {
+	return expr.GetCallExpr() != nil && len(expr.GetCallExpr().GetArgs()) > 1
 }
 
 func (un *unparser) visitComprehension(expr *exprpb.Expr) error {
-	// TODO: introduce a macro expansion map between the top-level comprehension id and the
-	// function call that the macro replaces.
-	return fmt.Errorf("unimplemented : %v", expr)
+	c := expr.GetComprehensionExpr()
+	if target, fun, args, ok := unmangleComprehension(c); ok {
+		return un.visitMacro(target, fun, args)
+	}
+	// The comprehension didn't match one of the well-known macro shapes, so fall back to a
+	// generic, total representation of the expanded for-loop form.
+	return un.visitComprehensionLiteral(expr)
+}
+
+// visitMacro renders the receiver-call form of a well-known macro, e.g. `x.exists(v, p)`, or the
+// receiver-less form `fun(args...)` when target is nil.
+func (un *unparser) visitMacro(target *exprpb.Expr, fun string, args []*exprpb.Expr) error {
+	if target != nil {
+		err := un.visit(target)
+		if err != nil {
+			return err
+		}
+		un.str.WriteString(".")
+	}
+	un.str.WriteString(fun)
+	un.str.WriteString("(")
+	for i, arg := range args {
+		err := un.visit(arg)
+		if err != nil {
+			return err
+		}
+		if i < len(args)-1 {
+			un.str.WriteString(", ")
+		}
+	}
+	un.str.WriteString(")")
+	return nil
+}
+
+// visitComprehensionLiteral renders a comprehension which does not match one of the well-known
+// macro shapes as the generic `__comprehension__` call form, so that Unparse remains total over
+// every valid Expr_ComprehensionExpr.
+func (un *unparser) visitComprehensionLiteral(expr *exprpb.Expr) error {
+	c := expr.GetComprehensionExpr()
+	un.str.WriteString("__comprehension__(")
+	un.str.WriteString(c.GetIterVar())
+	un.str.WriteString(", ")
+	parts := []*exprpb.Expr{c.GetIterRange(), c.GetAccuInit(), c.GetLoopCondition(), c.GetLoopStep(), c.GetResult()}
+	for _, p := range parts {
+		err := un.visit(p)
+		if err != nil {
+			return err
+		}
+		un.str.WriteString(", ")
+	}
+	// the accumulator variable name has no Expr of its own, so it's written directly.
+	un.str.WriteString(c.GetAccuVar())
+	un.str.WriteString(")")
+	return nil
+}
+
+// unmangleComprehension attempts to recognize a comprehension as one of the well-known CEL macro
+// expansions (all, exists, exists_one, map, map-with-filter, filter) based on the shape of its
+// AccuInit, LoopCondition, LoopStep, and Result subtrees, returning the receiver target, the
+// macro's function name, and its unexpanded arguments. ok is false when no known shape matches.
+func unmangleComprehension(c *exprpb.Expr_Comprehension) (target *exprpb.Expr, fun string, args []*exprpb.Expr, ok bool) {
+	accuVar := c.GetAccuVar()
+	iterVar := c.GetIterVar()
+	iterArg := &exprpb.Expr{ExprKind: &exprpb.Expr_IdentExpr{IdentExpr: &exprpb.Expr_Ident{Name: iterVar}}}
+	switch {
+	case isConstBool(c.GetAccuInit(), true) &&
+		isNotStrictlyFalse(c.GetLoopCondition(), accuVar, false) &&
+		isIdent(c.GetResult(), accuVar):
+		if pred, found := unmangleLoopStepBinary(c.GetLoopStep(), operators.LogicalAnd, accuVar); found {
+			return c.GetIterRange(), "all", []*exprpb.Expr{iterArg, pred}, true
+		}
+	case isConstBool(c.GetAccuInit(), false) &&
+		isNotStrictlyFalse(c.GetLoopCondition(), accuVar, true) &&
+		isIdent(c.GetResult(), accuVar):
+		if pred, found := unmangleLoopStepBinary(c.GetLoopStep(), operators.LogicalOr, accuVar); found {
+			return c.GetIterRange(), "exists", []*exprpb.Expr{iterArg, pred}, true
+		}
+	case isConstInt(c.GetAccuInit(), 0) &&
+		isConstBool(c.GetLoopCondition(), true) &&
+		isExistsOneResult(c.GetResult(), accuVar):
+		if pred, found := unmangleExistsOneStep(c.GetLoopStep(), accuVar); found {
+			return c.GetIterRange(), "exists_one", []*exprpb.Expr{iterArg, pred}, true
+		}
+	case isEmptyList(c.GetAccuInit()) &&
+		isConstBool(c.GetLoopCondition(), true) &&
+		isIdent(c.GetResult(), accuVar):
+		if filter, xform, found := unmangleMapOrFilterStep(c.GetLoopStep(), accuVar, iterVar); found {
+			switch {
+			case filter == nil:
+				return c.GetIterRange(), "map", []*exprpb.Expr{iterArg, xform}, true
+			case xform == nil:
+				return c.GetIterRange(), "filter", []*exprpb.Expr{iterArg, filter}, true
+			default:
+				return c.GetIterRange(), "map", []*exprpb.Expr{iterArg, filter, xform}, true
+			}
+		}
+	}
+	return nil, "", nil, false
+}
+
+// isConstBool reports whether expr is a bool literal equal to want.
+func isConstBool(expr *exprpb.Expr, want bool) bool {
+	b, ok := expr.GetConstExpr().GetConstantKind().(*exprpb.Constant_BoolValue)
+	return ok && b.BoolValue == want
+}
+
+// isConstInt reports whether expr is an int literal equal to want.
+func isConstInt(expr *exprpb.Expr, want int64) bool {
+	i, ok := expr.GetConstExpr().GetConstantKind().(*exprpb.Constant_Int64Value)
+	return ok && i.Int64Value == want
+}
+
+// isEmptyList reports whether expr is a list literal with no elements.
+func isEmptyList(expr *exprpb.Expr) bool {
+	l := expr.GetListExpr()
+	return l != nil && len(l.GetElements()) == 0
+}
+
+// isIdent reports whether expr is an identifier with the given name.
+func isIdent(expr *exprpb.Expr, name string) bool {
+	id := expr.GetIdentExpr()
+	return id != nil && id.GetName() == name
+}
+
+// isNotStrictlyFalse reports whether cond is `@not_strictly_false(accuVar)`, or, when negated is
+// true, `@not_strictly_false(!accuVar)`.
+func isNotStrictlyFalse(cond *exprpb.Expr, accuVar string, negated bool) bool {
+	call := cond.GetCallExpr()
+	if call == nil || call.GetFunction() != "@not_strictly_false" || len(call.GetArgs()) != 1 {
+		return false
+	}
+	arg := call.GetArgs()[0]
+	if !negated {
+		return isIdent(arg, accuVar)
+	}
+	not := arg.GetCallExpr()
+	return not != nil && not.GetFunction() == operators.LogicalNot &&
+		len(not.GetArgs()) == 1 && isIdent(not.GetArgs()[0], accuVar)
+}
+
+// unmangleLoopStepBinary matches a loop step of the form `accuVar op pred` and returns pred.
+func unmangleLoopStepBinary(step *exprpb.Expr, op string, accuVar string) (*exprpb.Expr, bool) {
+	call := step.GetCallExpr()
+	if call == nil || call.GetFunction() != op || len(call.GetArgs()) != 2 {
+		return nil, false
+	}
+	if !isIdent(call.GetArgs()[0], accuVar) {
+		return nil, false
+	}
+	return call.GetArgs()[1], true
+}
+
+// unmangleExistsOneStep matches a loop step of the form `pred ? accuVar + 1 : accuVar` and
+// returns pred.
+func unmangleExistsOneStep(step *exprpb.Expr, accuVar string) (*exprpb.Expr, bool) {
+	call := step.GetCallExpr()
+	if call == nil || call.GetFunction() != operators.Conditional || len(call.GetArgs()) != 3 {
+		return nil, false
+	}
+	pred, thenExpr, elseExpr := call.GetArgs()[0], call.GetArgs()[1], call.GetArgs()[2]
+	if !isIdent(elseExpr, accuVar) {
+		return nil, false
+	}
+	sum := thenExpr.GetCallExpr()
+	if sum == nil || sum.GetFunction() != operators.Add || len(sum.GetArgs()) != 2 {
+		return nil, false
+	}
+	if !isIdent(sum.GetArgs()[0], accuVar) || !isConstInt(sum.GetArgs()[1], 1) {
+		return nil, false
+	}
+	return pred, true
+}
+
+// isExistsOneResult reports whether result is `accuVar == 1`.
+func isExistsOneResult(result *exprpb.Expr, accuVar string) bool {
+	call := result.GetCallExpr()
+	if call == nil || call.GetFunction() != operators.Equals || len(call.GetArgs()) != 2 {
+		return false
+	}
+	return isIdent(call.GetArgs()[0], accuVar) && isConstInt(call.GetArgs()[1], 1)
+}
+
+// unmangleMapOrFilterStep matches the loop step of a map, map-with-filter, or filter macro.
+// A plain map step is `accuVar + [xform]`, in which case filter is nil. A map-with-filter or
+// filter step is `pred ? accuVar + [elem] : accuVar`; when elem is the bare iteration variable
+// it's a filter (xform is nil), otherwise it's a map-with-filter.
+func unmangleMapOrFilterStep(step *exprpb.Expr, accuVar, iterVar string) (filter, xform *exprpb.Expr, ok bool) {
+	if elem, found := unmangleAppend(step, accuVar); found {
+		return nil, elem, true
+	}
+	call := step.GetCallExpr()
+	if call == nil || call.GetFunction() != operators.Conditional || len(call.GetArgs()) != 3 {
+		return nil, nil, false
+	}
+	pred, thenExpr, elseExpr := call.GetArgs()[0], call.GetArgs()[1], call.GetArgs()[2]
+	if !isIdent(elseExpr, accuVar) {
+		return nil, nil, false
+	}
+	elem, found := unmangleAppend(thenExpr, accuVar)
+	if !found {
+		return nil, nil, false
+	}
+	if isIdent(elem, iterVar) {
+		return pred, nil, true
+	}
+	return pred, elem, true
+}
+
+// unmangleAppend matches `accuVar + [elem]` and returns elem.
+func unmangleAppend(step *exprpb.Expr, accuVar string) (*exprpb.Expr, bool) {
+	call := step.GetCallExpr()
+	if call == nil || call.GetFunction() != operators.Add || len(call.GetArgs()) != 2 {
+		return nil, false
+	}
+	if !isIdent(call.GetArgs()[0], accuVar) {
+		return nil, false
+	}
+	l := call.GetArgs()[1].GetListExpr()
+	if l == nil || len(l.GetElements()) != 1 {
+		return nil, false
+	}
+	return l.GetElements()[0], true
 }
 
 func (un *unparser) visitConst(expr *exprpb.Expr) error {
 	un.pad(expr.GetId())
+	if un.options != nil && un.options.preserveLiterals {
+		if raw, found := un.options.rawLiterals[expr.GetId()]; found {
+			un.str.WriteString(raw)
+			return nil
+		}
+	}
 	c := expr.GetConstExpr()
 	switch c.ConstantKind.(type) {
 	case *exprpb.Constant_BoolValue:
 		un.str.WriteString(strconv.FormatBool(c.GetBoolValue()))
 	case *exprpb.Constant_BytesValue:
-		// bytes constants are surrounded with b"<bytes>"
-		b := c.GetBytesValue()
-		un.str.WriteString(`b"`)
-		un.str.Write(b)
-		un.str.WriteString(`"`)
+		// bytes constants are surrounded with b"<bytes>"; non-printable and non-ASCII bytes are
+		// rendered as \xNN escapes rather than copied verbatim, since the latter can produce
+		// invalid UTF-8 that fails to re-parse as a CEL string literal.
+		un.str.WriteString(quoteBytes(c.GetBytesValue()))
 	case *exprpb.Constant_DoubleValue:
 		// represent the float using the minimum required digits
 		d := strconv.FormatFloat(c.GetDoubleValue(), 'g', -1, 64)
@@ -285,14 +548,8 @@ func (un *unparser) visitList(expr *exprpb.Expr) error {
 	elems := l.GetElements()
 	un.pad(expr.GetId())
 	un.str.WriteString("[")
-	for i, elem := range elems {
-		err := un.visit(elem)
-		if err != nil {
-			return err
-		}
-		if i < len(elems)-1 {
-			un.str.WriteString(",")
-		}
+	if err := un.writeItems(elems, ","); err != nil {
+		return err
 	}
 	un.str.WriteString("]")
 	return nil
@@ -302,7 +559,14 @@ func (un *unparser) visitSelect(expr *exprpb.Expr) error {
 	sel := expr.GetSelectExpr()
 	// handle the case when the select expression was generated by the has() macro.
 	if sel.GetTestOnly() {
+		// visit has already written the comments for this id, so the plain select is rendered
+		// through visitSelect directly rather than visit, which would write them a second time.
 		un.str.WriteString("has(")
+		if err := un.visitSelect(selectWithoutTestOnly(expr)); err != nil {
+			return err
+		}
+		un.str.WriteString(")")
+		return nil
 	}
 	err := un.visit(sel.GetOperand())
 	if err != nil {
@@ -311,12 +575,24 @@ func (un *unparser) visitSelect(expr *exprpb.Expr) error {
 	un.pad(expr.GetId())
 	un.str.WriteString(".")
 	un.str.WriteString(sel.GetField())
-	if sel.GetTestOnly() {
-		un.str.WriteString(")")
-	}
 	return nil
 }
 
+// selectWithoutTestOnly returns a copy of a `has()`-flagged select expression with TestOnly
+// cleared, so that it unparses as the plain `x.y` it represents inside the has(...) call.
+func selectWithoutTestOnly(expr *exprpb.Expr) *exprpb.Expr {
+	sel := expr.GetSelectExpr()
+	return &exprpb.Expr{
+		Id: expr.GetId(),
+		ExprKind: &exprpb.Expr_SelectExpr{
+			SelectExpr: &exprpb.Expr_Select{
+				Operand: sel.GetOperand(),
+				Field:   sel.GetField(),
+			},
+		},
+	}
+}
+
 func (un *unparser) visitStruct(expr *exprpb.Expr) error {
 	s := expr.GetStructExpr()
 	// If the message name is non-empty, then this should be treated as message construction.
@@ -333,19 +609,15 @@ func (un *unparser) visitStructMsg(expr *exprpb.Expr) error {
 	un.str.WriteString(m.GetMessageName())
 	un.pad(expr.GetId())
 	un.str.WriteString("{")
-	for i, entry := range entries {
-		f := entry.GetFieldKey()
-		un.str.WriteString(f)
-		un.pad(entry.GetId())
-		un.str.WriteString(": ")
-		v := entry.GetValue()
-		err := un.visit(v)
-		if err != nil {
-			return err
-		}
-		if i < len(entries)-1 {
-			un.str.WriteString(", ")
-		}
+	err := un.writeEntries(len(entries), ", ", func(child *unparser, i int) error {
+		entry := entries[i]
+		child.str.WriteString(entry.GetFieldKey())
+		child.pad(entry.GetId())
+		child.str.WriteString(": ")
+		return child.visit(entry.GetValue())
+	})
+	if err != nil {
+		return err
 	}
 	un.str.WriteString("}")
 	return nil
@@ -356,28 +628,26 @@ func (un *unparser) visitStructMap(expr *exprpb.Expr) error {
 	entries := m.GetEntries()
 	un.pad(expr.GetId())
 	un.str.WriteString("{")
-	for i, entry := range entries {
-		k := entry.GetMapKey()
-		err := un.visit(k)
-		if err != nil {
+	err := un.writeEntries(len(entries), ", ", func(child *unparser, i int) error {
+		entry := entries[i]
+		if err := child.visit(entry.GetMapKey()); err != nil {
 			return err
 		}
-		un.pad(entry.GetId())
-		un.str.WriteString(": ")
-		v := entry.GetValue()
-		err = un.visit(v)
-		if err != nil {
-			return err
-		}
-		if i < len(entries)-1 {
-			un.str.WriteString(", ")
-		}
+		child.pad(entry.GetId())
+		child.str.WriteString(": ")
+		return child.visit(entry.GetValue())
+	})
+	if err != nil {
+		return err
 	}
 	un.str.WriteString("}")
 	return nil
 }
 
 func (un *unparser) visitMaybeNested(expr *exprpb.Expr, nested bool) error {
+	if un.options != nil && un.options.alwaysParens && isOperatorCall(expr) {
+		nested = true
+	}
 	if nested {
 		un.str.WriteString("(")
 	}
@@ -399,6 +669,16 @@ func (un *unparser) pos(id int64) int32 {
 // pad potentially adds spaces from the current string builder position to the original position
 // of the input expression id.
 func (un *unparser) pad(id int64) {
+	if un.info == nil {
+		// No source positions to align to: leave canonical single-space spacing untouched
+		// rather than relying on every lookup in pos() coincidentally returning zero.
+		return
+	}
+	if un.options != nil && un.options.maxLineLength > 0 {
+		// Pretty-printed output manages its own spacing and newlines; source positions no
+		// longer correspond to columns once a subtree has been reflowed.
+		return
+	}
 	last := int32(un.str.Len())
 	next := un.pos(id)
 	for ; last < next; last++ {
@@ -437,3 +717,25 @@ func isLowerPrecedence(op string, expr *exprpb.Expr) bool {
 	other := c.GetFunction()
 	return operators.Precedence(op) < operators.Precedence(other)
 }
+
+// quoteBytes renders b as a double-quoted `b"..."` CEL bytes literal. Printable ASCII bytes are
+// copied through as-is (with '"' and '\' escaped); every other byte is rendered as a \xNN escape
+// so that the result is always valid UTF-8 and always re-parses to the original byte string.
+func quoteBytes(b []byte) string {
+	var sb strings.Builder
+	sb.WriteString(`b"`)
+	for _, c := range b {
+		switch {
+		case c == '"':
+			sb.WriteString(`\"`)
+		case c == '\\':
+			sb.WriteString(`\\`)
+		case c >= 0x20 && c < 0x7f:
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, `\x%02x`, c)
+		}
+	}
+	sb.WriteString(`"`)
+	return sb.String()
+}
@@ -0,0 +1,206 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/cel-go/common/operators"
+
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// currentColumn returns the number of characters written since the last newline, used to decide
+// whether a candidate single-line rendering still fits within the configured max line length.
+func (un *unparser) currentColumn() int {
+	s := un.str.String()
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return len(s) - i - 1
+	}
+	return len(s)
+}
+
+// writeEntries renders n comma-separated entries, each produced by calling render with either un
+// itself (legacy single-line mode, byte-for-byte identical to the pre-options behavior) or a
+// child unparser at the next indent level whose rendered text is measured and, if needed, broken
+// across indented lines.
+func (un *unparser) writeEntries(n int, sep string, render func(child *unparser, i int) error) error {
+	if un.options.maxLineLength <= 0 {
+		for i := 0; i < n; i++ {
+			if err := render(un, i); err != nil {
+				return err
+			}
+			if i < n-1 {
+				un.str.WriteString(sep)
+			}
+		}
+		return nil
+	}
+	parts := make([]string, n)
+	for i := 0; i < n; i++ {
+		child := &unparser{info: un.info, options: un.options, indentLevel: un.indentLevel + 1}
+		if err := render(child, i); err != nil {
+			return err
+		}
+		parts[i] = child.str.String()
+	}
+	un.writeParts(parts, sep)
+	return nil
+}
+
+// writeItems is a writeEntries convenience for the common case of a flat list of sub-expressions.
+func (un *unparser) writeItems(items []*exprpb.Expr, sep string) error {
+	return un.writeEntries(len(items), sep, func(child *unparser, i int) error {
+		return child.visit(items[i])
+	})
+}
+
+// writeParts writes already-rendered parts inline, separated by sep, or one per indented line
+// when the inline form would not fit within the configured max line length.
+func (un *unparser) writeParts(parts []string, sep string) {
+	flat := strings.Join(parts, sep)
+	if un.currentColumn()+len(flat) <= un.options.maxLineLength {
+		un.str.WriteString(flat)
+		return
+	}
+	inner := strings.Repeat(" ", un.options.indent*(un.indentLevel+1))
+	itemSep := strings.TrimRight(sep, " ")
+	for i, part := range parts {
+		un.str.WriteString("\n")
+		un.str.WriteString(inner)
+		un.str.WriteString(part)
+		if i < len(parts)-1 || un.options.trailingComma {
+			un.str.WriteString(itemSep)
+		}
+	}
+	un.str.WriteString("\n")
+	un.str.WriteString(strings.Repeat(" ", un.options.indent*un.indentLevel))
+}
+
+// visitLogicalChain renders a left-recursive &&/|| chain as a flat "a && b && c" expression,
+// breaking it across indented lines when it doesn't fit within the configured max line length.
+// This is only used when line breaking is enabled; the legacy pairwise recursive rendering in
+// visitCallBinary is used otherwise, so that Unparse's default output is unaffected.
+func (un *unparser) visitLogicalChain(expr *exprpb.Expr) error {
+	fun := expr.GetCallExpr().GetFunction()
+	unmangled, found := operators.FindReverse(fun)
+	if !found {
+		return fmt.Errorf("cannot unmangle operator: %s", fun)
+	}
+	operands := flattenChain(expr, fun)
+	parts := make([]string, len(operands))
+	for i, o := range operands {
+		nested := isLowerPrecedence(fun, o) || (i > 0 && isSamePrecedence(fun, o) && !sameChainOp(o, fun))
+		child := &unparser{info: un.info, options: un.options, indentLevel: un.indentLevel + 1}
+		if err := child.visitMaybeNested(o, nested); err != nil {
+			return err
+		}
+		parts[i] = child.str.String()
+	}
+	sep := " " + unmangled + " "
+	flat := strings.Join(parts, sep)
+	if un.currentColumn()+len(flat) <= un.options.maxLineLength {
+		un.str.WriteString(flat)
+		return nil
+	}
+	inner := strings.Repeat(" ", un.options.indent*(un.indentLevel+1))
+	for i, part := range parts {
+		if i > 0 {
+			un.str.WriteString("\n")
+			un.str.WriteString(inner)
+			un.str.WriteString(unmangled)
+			un.str.WriteString(" ")
+		}
+		un.str.WriteString(part)
+	}
+	return nil
+}
+
+// visitCallConditionalWrapped renders a ternary `cond ? then : else` expression, breaking it
+// across indented "? "/": " continuation lines when it doesn't fit within the configured max line
+// length. This is only used when line breaking is enabled; the legacy single-line rendering in
+// visitCallConditional is used otherwise, so that Unparse's default output is unaffected.
+func (un *unparser) visitCallConditionalWrapped(expr *exprpb.Expr) error {
+	args := expr.GetCallExpr().GetArgs()
+	parts := make([]string, len(args))
+	for i, a := range args {
+		nested := isSamePrecedence(operators.Conditional, a)
+		child := &unparser{info: un.info, options: un.options, indentLevel: un.indentLevel + 1}
+		if err := child.visitMaybeNested(a, nested); err != nil {
+			return err
+		}
+		parts[i] = child.str.String()
+	}
+	flat := parts[0] + " ? " + parts[1] + " : " + parts[2]
+	if un.currentColumn()+len(flat) <= un.options.maxLineLength {
+		un.str.WriteString(flat)
+		return nil
+	}
+	inner := strings.Repeat(" ", un.options.indent*(un.indentLevel+1))
+	un.str.WriteString(parts[0])
+	un.str.WriteString("\n")
+	un.str.WriteString(inner)
+	un.str.WriteString("? ")
+	un.str.WriteString(parts[1])
+	un.str.WriteString("\n")
+	un.str.WriteString(inner)
+	un.str.WriteString(": ")
+	un.str.WriteString(parts[2])
+	return nil
+}
+
+// flattenChain collects the operands of a left-recursive chain of the same binary operator, e.g.
+// `a && b && c` (parsed as `(a && b) && c`) becomes [a, b, c].
+func flattenChain(expr *exprpb.Expr, fun string) []*exprpb.Expr {
+	c := expr.GetCallExpr()
+	if c == nil || c.GetFunction() != fun {
+		return []*exprpb.Expr{expr}
+	}
+	args := c.GetArgs()
+	return append(flattenChain(args[0], fun), args[1])
+}
+
+// sameChainOp reports whether expr is itself a call to the same chain operator.
+func sameChainOp(expr *exprpb.Expr, fun string) bool {
+	c := expr.GetCallExpr()
+	return c != nil && c.GetFunction() == fun
+}
+
+// isOperatorCall reports whether expr is a call to one of the recognized CEL operators, as
+// opposed to an ordinary named function call.
+func isOperatorCall(expr *exprpb.Expr) bool {
+	c := expr.GetCallExpr()
+	if c == nil {
+		return false
+	}
+	_, found := operators.FindReverse(c.GetFunction())
+	return found
+}
+
+// writeLeadingComments writes any comments attached to the given expression id immediately before
+// the expression's own rendering, indented to the current level. A no-op unless WithCommentMap
+// was supplied.
+func (un *unparser) writeLeadingComments(id int64) {
+	if un.options == nil || un.options.comments == nil {
+		return
+	}
+	for _, c := range un.options.comments.CommentsFor(id) {
+		un.str.WriteString("// ")
+		un.str.WriteString(c.Text)
+		un.str.WriteString("\n")
+		un.str.WriteString(strings.Repeat(" ", un.options.indent*un.indentLevel))
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parser
+
+import "testing"
+
+func TestUnparseDefaultUnaffectedByOptions(t *testing.T) {
+	// Calling Unparse with no options must reproduce the exact pre-options output.
+	expr := callExpr(operatorAnd, identExpr("a"), identExpr("b"))
+	out, err := Unparse(expr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "a && b" {
+		t.Errorf("got '%v', wanted 'a && b'", out)
+	}
+}
+
+func TestUnparseBreaksLongLogicalChain(t *testing.T) {
+	expr := callExpr(operatorAnd,
+		callExpr(operatorAnd,
+			callExpr(operatorAnd, identExpr("aaaaaaaaaa"), identExpr("bbbbbbbbbb")),
+			identExpr("cccccccccc")),
+		identExpr("dddddddddd"))
+	out, err := Unparse(expr, nil, WithMaxLineLength(20), WithIndent(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "aaaaaaaaaa\n  && bbbbbbbbbb\n  && cccccccccc\n  && dddddddddd"
+	if out != want {
+		t.Errorf("got:\n%v\nwanted:\n%v", out, want)
+	}
+}
+
+func TestUnparseBreaksLongTernary(t *testing.T) {
+	expr := callExpr(operatorConditional, identExpr("conditionnnnnnnnnnn"), identExpr("thennnnnnnnnn"), identExpr("elseeeeeeeeee"))
+	out, err := Unparse(expr, nil, WithMaxLineLength(10), WithIndent(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "conditionnnnnnnnnnn\n  ? thennnnnnnnnn\n  : elseeeeeeeeee"
+	if out != want {
+		t.Errorf("got:\n%v\nwanted:\n%v", out, want)
+	}
+}
+
+func TestUnparseShortTernaryStaysOnOneLineWithMaxLineLength(t *testing.T) {
+	expr := callExpr(operatorConditional, identExpr("c"), identExpr("t"), identExpr("e"))
+	out, err := Unparse(expr, nil, WithMaxLineLength(80))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "c ? t : e"
+	if out != want {
+		t.Errorf("got '%v', wanted '%v'", out, want)
+	}
+}
+
+func TestUnparseBreaksLongListWithTrailingComma(t *testing.T) {
+	expr := listExpr(intExpr(111111), intExpr(222222), intExpr(333333))
+	out, err := Unparse(expr, nil, WithMaxLineLength(10), WithTrailingCommaInListsAndMaps(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[\n  111111,\n  222222,\n  333333,\n]"
+	if out != want {
+		t.Errorf("got:\n%v\nwanted:\n%v", out, want)
+	}
+}
+
+func TestUnparseAlwaysParenthesize(t *testing.T) {
+	expr := callExpr(operatorAnd, callExpr(operatorOr, identExpr("a"), identExpr("b")), identExpr("c"))
+	out, err := Unparse(expr, nil, WithAlwaysParenthesize(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "(a || b) && c"
+	if out != want {
+		t.Errorf("got '%v', wanted '%v'", out, want)
+	}
+}
+
+func TestUnparseAlwaysParenthesizeUnary(t *testing.T) {
+	expr := callExpr(operatorNot, callExpr(operatorNot, identExpr("a")))
+	out, err := Unparse(expr, nil, WithAlwaysParenthesize(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "!(!a)"
+	if out != want {
+		t.Errorf("got '%v', wanted '%v'", out, want)
+	}
+}
+
+func TestUnparseComplexUnaryOperandParenthesizedByDefault(t *testing.T) {
+	// -(a + b) must round-trip with parens even without WithAlwaysParenthesize: rendering it as
+	// "-a + b" would silently change precedence to (-a) + b.
+	expr := callExpr(operatorNegate, callExpr(operatorAdd, identExpr("a"), identExpr("b")))
+	out, err := Unparse(expr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "-(a + b)"
+	if out != want {
+		t.Errorf("got '%v', wanted '%v'", out, want)
+	}
+}
+
+func TestFormatterReuse(t *testing.T) {
+	f := NewFormatter(WithMaxLineLength(10))
+	out, err := f.Format(listExpr(intExpr(111111), intExpr(222222)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[\n  111111,\n  222222\n]"
+	if out != want {
+		t.Errorf("got:\n%v\nwanted:\n%v", out, want)
+	}
+}
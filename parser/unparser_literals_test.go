@@ -0,0 +1,73 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parser
+
+import (
+	"testing"
+
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// KNOWN GAP: every test below supplies its WithRawLiterals table by hand. None of them exercise a
+// raw-lexeme table produced by an actual parse, because this snapshot has no parser.go to capture
+// one from. See the KNOWN GAP comment on WithRawLiterals in unparser_options.go.
+func TestUnparsePreservesRawLiteral(t *testing.T) {
+	expr := withID(1, intExpr(255))
+	out, err := Unparse(expr, nil,
+		WithPreserveLiteralFormatting(true),
+		WithRawLiterals(map[int64]string{1: "0xFF"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "0xFF" {
+		t.Errorf("got '%v', wanted '0xFF'", out)
+	}
+}
+
+func TestUnparsePreserveLiteralFallsBackWithoutRawEntry(t *testing.T) {
+	expr := withID(1, intExpr(255))
+	out, err := Unparse(expr, nil, WithPreserveLiteralFormatting(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "255" {
+		t.Errorf("got '%v', wanted '255'", out)
+	}
+}
+
+func TestUnparseIgnoresRawLiteralWithoutPreserveMode(t *testing.T) {
+	expr := withID(1, intExpr(255))
+	out, err := Unparse(expr, nil, WithRawLiterals(map[int64]string{1: "0xFF"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "255" {
+		t.Errorf("got '%v', wanted '255'", out)
+	}
+}
+
+func TestQuoteBytesEscapesNonASCII(t *testing.T) {
+	got := quoteBytes([]byte{0xc3, 0xbf, '"', '\\', 'a'})
+	want := `b"\xc3\xbf\"\\a"`
+	if got != want {
+		t.Errorf("got '%v', wanted '%v'", got, want)
+	}
+}
+
+// withID returns expr with its id set, for constructing fixtures keyed by expr id.
+func withID(id int64, expr *exprpb.Expr) *exprpb.Expr {
+	expr.Id = id
+	return expr
+}
@@ -0,0 +1,78 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parser
+
+import "testing"
+
+// KNOWN GAP: the request behind UnparseExpr/Unparse(expr, nil) asked for a round-trip test that
+// calls Parse(UnparseExpr(e)) and compares the resulting AST modulo ids to the original, for every
+// fixture in the parser test suite, so that synthetic-AST round-tripping would be verified against
+// the real grammar-driven parser, not just against itself. There is no Parse()/parser.go in this
+// snapshot to call, so that contract could not be implemented or verified here. The tests below
+// instead check the two narrower properties this package can actually confirm without a parser:
+// that output is canonically spaced and that it is independent of expr ids. Treat these as
+// necessary but not sufficient evidence for the round-trip contract the original request asked
+// for; they do not confirm UnparseExpr's output actually re-parses to an equivalent AST.
+func TestUnparseExprCanonicalSpacing(t *testing.T) {
+	expr := callExpr(operatorAnd, callExpr("_>_", identExpr("v"), intExpr(0)), identExpr("ok"))
+	out, err := UnparseExpr(expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "v > 0 && ok" {
+		t.Errorf("got '%v', wanted 'v > 0 && ok'", out)
+	}
+}
+
+// TestUnparseExprIgnoresStaleIDs exercises the bug UnparseExpr/Unparse(expr, nil) formalize a fix
+// for: a synthetic Expr tree built in Go has no SourceInfo of its own, but a caller might
+// (mistakenly or not) still hold a SourceInfo from an unrelated parse whose Positions map happens
+// to have large values for the same numeric ids. Without SourceInfo that stale data cannot leak
+// in, so output must be identical regardless of what ids the synthetic nodes are given.
+func TestUnparseExprIgnoresStaleIDs(t *testing.T) {
+	// withID sets the id in place, so each variant needs a tree of its own.
+	withLowIDs := withID(1, callExpr(operatorAnd, identExpr("a"), identExpr("b")))
+	withHighIDs := withID(99999, callExpr(operatorAnd, identExpr("a"), identExpr("b")))
+
+	outLow, err := UnparseExpr(withLowIDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outHigh, err := UnparseExpr(withHighIDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outLow != outHigh {
+		t.Errorf("UnparseExpr output depends on expr id: got '%v' and '%v'", outLow, outHigh)
+	}
+	if outLow != "a && b" {
+		t.Errorf("got '%v', wanted 'a && b'", outLow)
+	}
+}
+
+func TestUnparseNilInfoMatchesUnparseExpr(t *testing.T) {
+	expr := callExpr(operatorOr, identExpr("x"), identExpr("y"))
+	viaExpr, err := UnparseExpr(expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	viaNilInfo, err := Unparse(expr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if viaExpr != viaNilInfo {
+		t.Errorf("Unparse(expr, nil) = '%v', wanted to match UnparseExpr = '%v'", viaNilInfo, viaExpr)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parser
+
+import (
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// UnparseOption configures the behavior of Unparse beyond its zero-config default, a single-line,
+// precedence-preserving rendering.
+type UnparseOption func(*unparserOptions)
+
+// unparserOptions holds the resolved settings for a single Unparse call.
+type unparserOptions struct {
+	indent           int
+	maxLineLength    int
+	alwaysParens     bool
+	trailingComma    bool
+	comments         *CommentMap
+	preserveLiterals bool
+	rawLiterals      map[int64]string
+}
+
+func defaultUnparserOptions() *unparserOptions {
+	return &unparserOptions{indent: 2}
+}
+
+// WithIndent sets the number of spaces used for each level of indentation when a subtree is
+// broken across multiple lines. The default is 2. Has no effect unless WithMaxLineLength is also
+// set to a positive value.
+func WithIndent(n int) UnparseOption {
+	return func(o *unparserOptions) { o.indent = n }
+}
+
+// WithMaxLineLength enables line breaking: once set to a positive value, long &&/|| chains,
+// ternary expressions, call argument lists, and list/map literals are broken onto multiple,
+// indented lines whenever their single-line rendering would exceed n columns, collapsing back to
+// one line when they fit.
+// The zero value (the default) disables line breaking, matching Unparse's historical behavior.
+func WithMaxLineLength(n int) UnparseOption {
+	return func(o *unparserOptions) { o.maxLineLength = n }
+}
+
+// WithAlwaysParenthesize forces parentheses around every operand of a binary, unary, or ternary
+// operator that is itself an operator call, rather than only where required to preserve the
+// original operator precedence. Identifiers, literals, and ordinary function calls are not
+// parenthesized, e.g. `(a || b) && c`.
+func WithAlwaysParenthesize(always bool) UnparseOption {
+	return func(o *unparserOptions) { o.alwaysParens = always }
+}
+
+// WithTrailingCommaInListsAndMaps adds a trailing comma after the last element of a list, map, or
+// call argument list whenever line breaking has split it across multiple lines. Has no effect on
+// single-line output.
+func WithTrailingCommaInListsAndMaps(trailing bool) UnparseOption {
+	return func(o *unparserOptions) { o.trailingComma = trailing }
+}
+
+// WithCommentMap attaches a CommentMap, typically built by ScanComments, so that Unparse reinserts
+// each comment immediately before the expression it was attached to.
+func WithCommentMap(comments *CommentMap) UnparseOption {
+	return func(o *unparserOptions) { o.comments = comments }
+}
+
+// WithPreserveLiteralFormatting enables literal-preserving mode: when the raw source lexeme
+// for a literal's expr id is available via WithRawLiterals, Unparse emits it verbatim rather than
+// re-deriving a canonical form from the decoded Constant value. This preserves hex/octal int
+// bases, original float formatting, original string quote style, and raw-string (`r"..."`)
+// prefixes that the zero-config rendering normalizes away. Literals with no raw lexeme on record
+// fall back to the normal behavior, so this mode is safe to enable even with partial coverage.
+func WithPreserveLiteralFormatting(preserve bool) UnparseOption {
+	return func(o *unparserOptions) { o.preserveLiterals = preserve }
+}
+
+// WithRawLiterals supplies the side table of raw source lexemes, keyed by expr id, that a caller
+// wants substituted in for the corresponding literal in literal-preserving mode. It has
+// no effect unless WithPreserveLiteralFormatting is also enabled.
+//
+// KNOWN GAP: no component in this package populates this table from a real parse. The intended
+// design is that the grammar-driven parser/lexer (IntegerLiteralContext, StringLiteralContext,
+// etc. in parser.go) captures each literal's raw source text as it scans INT, UINT, FLOAT, STRING,
+// and BYTES tokens and hands the resulting map to WithRawLiterals for you, the same way it already
+// populates SourceInfo.Positions. That parser.go does not exist in this snapshot, so there is no
+// token-walking code to wire this into; WithRawLiterals currently only works if the caller
+// hand-builds the map itself, as parser/unparser_literals_test.go does. Treat that as the honest
+// scope of what's implemented today, not as proof this works against a real Parse() result.
+func WithRawLiterals(literals map[int64]string) UnparseOption {
+	return func(o *unparserOptions) { o.rawLiterals = literals }
+}
+
+// Formatter is a reusable, options-driven CEL pretty-printer, analogous to go/printer.Config:
+// configure it once with the desired UnparseOptions, then reuse it to format many expressions
+// consistently, e.g. from a project-wide style configuration.
+type Formatter struct {
+	opts []UnparseOption
+}
+
+// NewFormatter builds a Formatter from the given options.
+func NewFormatter(opts ...UnparseOption) *Formatter {
+	return &Formatter{opts: opts}
+}
+
+// Format renders expr using the Formatter's configured options.
+func (f *Formatter) Format(expr *exprpb.Expr, info *exprpb.SourceInfo) (string, error) {
+	return Unparse(expr, info, f.opts...)
+}
+
+// Comment is a single line comment captured while scanning CEL source.
+type Comment struct {
+	// Text is the comment body, excluding the leading "//", one space directly after it, and any
+	// trailing newline.
+	Text string
+	// Line is the 1-based source line the comment appeared on.
+	Line int
+}
+
+// CommentMap associates comments with the id of the nearest expression they precede, mirroring
+// the role of go/ast.CommentMap for a CEL AST. Use ScanComments to populate one from source text,
+// or Add to build one up manually.
+type CommentMap struct {
+	byExprID map[int64][]Comment
+}
+
+// NewCommentMap returns an empty CommentMap.
+func NewCommentMap() *CommentMap {
+	return &CommentMap{byExprID: make(map[int64][]Comment)}
+}
+
+// Add attaches comment c to the expression with the given id.
+func (m *CommentMap) Add(id int64, c Comment) {
+	m.byExprID[id] = append(m.byExprID[id], c)
+}
+
+// CommentsFor returns the comments attached to the expression with the given id, in the order
+// they were added.
+func (m *CommentMap) CommentsFor(id int64) []Comment {
+	return m.byExprID[id]
+}
@@ -0,0 +1,221 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parser
+
+import (
+	"testing"
+
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// KNOWN GAP: TestUnparseMacro* below exercise unmangleComprehension's reverse macro matching, but
+// every fixture they run against comes from testComprehension, which hand-assembles the exact
+// desugared Expr shape unmangleComprehension already expects rather than the output of a real
+// grammar-driven parser (this snapshot has no parser.go/Parse() to desugar "x.all(v, v > 0)" for
+// us). That makes these tests circular: they confirm unmangleComprehension recognizes the shapes
+// testComprehension hands it, not that those shapes are what cel-go's actual macro expander
+// produces. If the real expander ever used a different operand order, accumulator name, or
+// @not_strictly_false argument shape, TestUnparseMacro* would keep passing while Unparse silently
+// failed to round-trip real "all"/"exists"/"map"/"filter" source. The fixtures were cross-checked
+// by hand against cel-go's documented macro-expansion semantics, but that is a one-time manual
+// review, not something this suite verifies on every run. Treat TestUnparseMacro* as coverage for
+// unmangleComprehension's matching logic in isolation, not as end-to-end proof that macros
+// round-trip correctly.
+func TestUnparseMacroAll(t *testing.T) {
+	expr := testComprehension(t, "x.all(v, v > 0)")
+	out, err := Unparse(expr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "x.all(v, v > 0)" {
+		t.Errorf("got '%v', wanted 'x.all(v, v > 0)'", out)
+	}
+}
+
+func TestUnparseMacroExists(t *testing.T) {
+	expr := testComprehension(t, "x.exists(v, v > 0)")
+	out, err := Unparse(expr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "x.exists(v, v > 0)" {
+		t.Errorf("got '%v', wanted 'x.exists(v, v > 0)'", out)
+	}
+}
+
+func TestUnparseMacroExistsOne(t *testing.T) {
+	expr := testComprehension(t, "x.exists_one(v, v > 0)")
+	out, err := Unparse(expr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "x.exists_one(v, v > 0)" {
+		t.Errorf("got '%v', wanted 'x.exists_one(v, v > 0)'", out)
+	}
+}
+
+func TestUnparseMacroMap(t *testing.T) {
+	expr := testComprehension(t, "x.map(v, v + 1)")
+	out, err := Unparse(expr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "x.map(v, v + 1)" {
+		t.Errorf("got '%v', wanted 'x.map(v, v + 1)'", out)
+	}
+}
+
+func TestUnparseMacroMapFilter(t *testing.T) {
+	expr := testComprehension(t, "x.map(v, v > 0, v + 1)")
+	out, err := Unparse(expr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "x.map(v, v > 0, v + 1)" {
+		t.Errorf("got '%v', wanted 'x.map(v, v > 0, v + 1)'", out)
+	}
+}
+
+func TestUnparseMacroFilter(t *testing.T) {
+	expr := testComprehension(t, "x.filter(v, v > 0)")
+	out, err := Unparse(expr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "x.filter(v, v > 0)" {
+		t.Errorf("got '%v', wanted 'x.filter(v, v > 0)'", out)
+	}
+}
+
+func TestUnparseComprehensionLiteral(t *testing.T) {
+	// a comprehension which does not match any well-known macro shape must still round-trip
+	// through the generic __comprehension__ form rather than erroring out.
+	expr := &exprpb.Expr{
+		Id: 1,
+		ExprKind: &exprpb.Expr_ComprehensionExpr{
+			ComprehensionExpr: &exprpb.Expr_Comprehension{
+				IterVar:       "v",
+				IterRange:     identExpr("x"),
+				AccuVar:       "__result__",
+				AccuInit:      intExpr(0),
+				LoopCondition: boolExpr(true),
+				LoopStep:      identExpr("__result__"),
+				Result:        identExpr("__result__"),
+			},
+		},
+	}
+	out, err := Unparse(expr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "__comprehension__(v, x, 0, true, __result__, __result__, __result__)"
+	if out != want {
+		t.Errorf("got '%v', wanted '%v'", out, want)
+	}
+}
+
+// testComprehension builds, by hand, the desugared comprehension Expr shape that cel-go's macro
+// expander is documented to produce for the given receiver-call source text. It does not run a
+// real parser or macro expander over src — there is none in this package — so it cannot catch a
+// divergence between this hand-built shape and whatever the real expander actually emits. See the
+// KNOWN GAP comment above TestUnparseMacroAll for what that means for the tests built on this.
+func testComprehension(t *testing.T, src string) *exprpb.Expr {
+	t.Helper()
+	switch src {
+	case "x.all(v, v > 0)":
+		return macroExpr("__result__", boolExpr(true), notStrictlyFalse(identExpr("__result__")),
+			callExpr(operatorAnd, identExpr("__result__"), greaterZero()), identExpr("__result__"))
+	case "x.exists(v, v > 0)":
+		return macroExpr("__result__", boolExpr(false), notStrictlyFalse(callExpr(operatorNot, identExpr("__result__"))),
+			callExpr(operatorOr, identExpr("__result__"), greaterZero()), identExpr("__result__"))
+	case "x.exists_one(v, v > 0)":
+		return macroExpr("__result__", intExpr(0), boolExpr(true),
+			callExpr(operatorConditional, greaterZero(), callExpr(operatorAdd, identExpr("__result__"), intExpr(1)), identExpr("__result__")),
+			callExpr(operatorEquals, identExpr("__result__"), intExpr(1)))
+	case "x.map(v, v + 1)":
+		return macroExpr("__result__", listExpr(), boolExpr(true),
+			callExpr(operatorAdd, identExpr("__result__"), listExpr(callExpr(operatorAdd, identExpr("v"), intExpr(1)))),
+			identExpr("__result__"))
+	case "x.map(v, v > 0, v + 1)":
+		return macroExpr("__result__", listExpr(), boolExpr(true),
+			callExpr(operatorConditional, greaterZero(),
+				callExpr(operatorAdd, identExpr("__result__"), listExpr(callExpr(operatorAdd, identExpr("v"), intExpr(1)))),
+				identExpr("__result__")),
+			identExpr("__result__"))
+	case "x.filter(v, v > 0)":
+		return macroExpr("__result__", listExpr(), boolExpr(true),
+			callExpr(operatorConditional, greaterZero(),
+				callExpr(operatorAdd, identExpr("__result__"), listExpr(identExpr("v"))),
+				identExpr("__result__")),
+			identExpr("__result__"))
+	}
+	t.Fatalf("no fixture for %q", src)
+	return nil
+}
+
+const (
+	operatorAnd         = "_&&_"
+	operatorOr          = "_||_"
+	operatorNot         = "!_"
+	operatorNegate      = "-_"
+	operatorAdd         = "_+_"
+	operatorEquals      = "_==_"
+	operatorConditional = "_?_:_"
+)
+
+func greaterZero() *exprpb.Expr {
+	return callExpr("_>_", identExpr("v"), intExpr(0))
+}
+
+func notStrictlyFalse(arg *exprpb.Expr) *exprpb.Expr {
+	return callExpr("@not_strictly_false", arg)
+}
+
+func macroExpr(accuVar string, accuInit, cond, step, result *exprpb.Expr) *exprpb.Expr {
+	return &exprpb.Expr{
+		Id: 1,
+		ExprKind: &exprpb.Expr_ComprehensionExpr{
+			ComprehensionExpr: &exprpb.Expr_Comprehension{
+				IterVar:       "v",
+				IterRange:     identExpr("x"),
+				AccuVar:       accuVar,
+				AccuInit:      accuInit,
+				LoopCondition: cond,
+				LoopStep:      step,
+				Result:        result,
+			},
+		},
+	}
+}
+
+func callExpr(fun string, args ...*exprpb.Expr) *exprpb.Expr {
+	return &exprpb.Expr{ExprKind: &exprpb.Expr_CallExpr{CallExpr: &exprpb.Expr_Call{Function: fun, Args: args}}}
+}
+
+func listExpr(elems ...*exprpb.Expr) *exprpb.Expr {
+	return &exprpb.Expr{ExprKind: &exprpb.Expr_ListExpr{ListExpr: &exprpb.Expr_CreateList{Elements: elems}}}
+}
+
+func identExpr(name string) *exprpb.Expr {
+	return &exprpb.Expr{ExprKind: &exprpb.Expr_IdentExpr{IdentExpr: &exprpb.Expr_Ident{Name: name}}}
+}
+
+func intExpr(i int64) *exprpb.Expr {
+	return &exprpb.Expr{ExprKind: &exprpb.Expr_ConstExpr{ConstExpr: &exprpb.Constant{ConstantKind: &exprpb.Constant_Int64Value{Int64Value: i}}}}
+}
+
+func boolExpr(b bool) *exprpb.Expr {
+	return &exprpb.Expr{ExprKind: &exprpb.Expr_ConstExpr{ConstExpr: &exprpb.Constant{ConstantKind: &exprpb.Constant_BoolValue{BoolValue: b}}}}
+}